@@ -0,0 +1,190 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+)
+
+const (
+	devCAKeyFile     = "ca-key.pem"
+	devCACertFile    = "ca-cert.pem"
+	devAgentKeyFile  = "agent-key.pem"
+	devAgentCertFile = "agent-cert.pem"
+)
+
+// ssntpRoleOID tags a leaf certificate with the SSNTP role it is
+// authorised for, the same way createCertificates does for
+// production-issued certificates.
+var ssntpRoleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 58672, 1, 1}
+
+// bootstrapDevMode is called from main when --dev is set.  When
+// -cacert/-cert were not also supplied, it generates, or reuses, a
+// throwaway CA and agent certificate under devPKIDir and points
+// serverCertPath/clientCertPath at them so connectToServer can Dial
+// without any pre-provisioned material.  It must only ever be called
+// behind the explicit --dev flag: the role OID it bakes into the leaf
+// certificate is not something a production deployment should trust.
+func bootstrapDevMode() error {
+	if serverCertPath != "" || clientCertPath != "" {
+		glog.Warning("--dev set but -cacert/-cert were also supplied; using the provided certificates")
+		return nil
+	}
+
+	glog.Warning("Running with --dev: launcher will trust an auto-generated, insecure CA.  Never use this in production")
+
+	caCertPath, agentCertPath, err := createOrReuseDevPKI(devPKIDir, ssntp.AGENT)
+	if err != nil {
+		return err
+	}
+
+	serverCertPath = caCertPath
+	clientCertPath = agentCertPath
+
+	return nil
+}
+
+// createOrReuseDevPKI creates, or reuses, a throwaway CA and agent
+// certificate under dir.  It takes dir as a parameter, rather than
+// reading the devPKIDir constant directly, so the reuse-vs-generate
+// branch below can be exercised against a throwaway directory in
+// tests.
+func createOrReuseDevPKI(dir string, role ssntp.Role) (caCertPath, agentCertPath string, err error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("unable to create dev PKI directory %s: %v", dir, err)
+	}
+
+	caKeyPath := path.Join(dir, devCAKeyFile)
+	caCertPath = path.Join(dir, devCACertFile)
+	agentKeyPath := path.Join(dir, devAgentKeyFile)
+	agentCertPath = path.Join(dir, devAgentCertFile)
+
+	if fileExists(caCertPath) && fileExists(agentCertPath) {
+		glog.Warningf("Reusing existing dev PKI material in %s", dir)
+		return caCertPath, agentCertPath, nil
+	}
+
+	caKey, caCert, err := createDevCACert(caKeyPath, caCertPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := createDevAgentCert(role, caKey, caCert, agentKeyPath, agentCertPath); err != nil {
+		return "", "", err
+	}
+
+	glog.Infof("Generated new dev PKI material in %s", dir)
+
+	return caCertPath, agentCertPath, nil
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func createDevCACert(keyPath, certPath string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate dev CA key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ciao-launcher dev CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to self-sign dev CA cert: %v", err)
+	}
+
+	if err := writePEMFile(keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEMFile(certPath, "CERTIFICATE", der); err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse dev CA cert: %v", err)
+	}
+
+	return key, cert, nil
+}
+
+func createDevAgentCert(role ssntp.Role, caKey *rsa.PrivateKey, caCert *x509.Certificate, keyPath, certPath string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("unable to generate dev agent key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ciao-launcher dev agent"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{
+			{
+				Id:    ssntpRoleOID,
+				Value: []byte(role.String()),
+			},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("unable to issue dev agent cert: %v", err)
+	}
+
+	if err := writePEMFile(keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)); err != nil {
+		return err
+	}
+
+	return writePEMFile(certPath, "CERTIFICATE", der)
+}
+
+func writePEMFile(p, blockType string, bytes []byte) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", p, err)
+	}
+	defer f.Close()
+
+	return pem.Encode(f, &pem.Block{Type: blockType, Bytes: bytes})
+}
@@ -0,0 +1,63 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ciao-project/ciao/ssntp"
+)
+
+func TestCreateOrReuseDevPKIGeneratesThenReuses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devpki-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCertPath, agentCertPath, err := createOrReuseDevPKI(dir, ssntp.AGENT)
+	if err != nil {
+		t.Fatalf("createOrReuseDevPKI: %v", err)
+	}
+	if !fileExists(caCertPath) || !fileExists(agentCertPath) {
+		t.Fatalf("expected cert material to exist under %s", dir)
+	}
+
+	firstCA, err := ioutil.ReadFile(caCertPath)
+	if err != nil {
+		t.Fatalf("reading generated CA cert: %v", err)
+	}
+
+	caCertPath2, agentCertPath2, err := createOrReuseDevPKI(dir, ssntp.AGENT)
+	if err != nil {
+		t.Fatalf("createOrReuseDevPKI (reuse): %v", err)
+	}
+	if caCertPath2 != caCertPath || agentCertPath2 != agentCertPath {
+		t.Fatalf("reuse returned different paths: got %s/%s, want %s/%s",
+			caCertPath2, agentCertPath2, caCertPath, agentCertPath)
+	}
+
+	secondCA, err := ioutil.ReadFile(caCertPath2)
+	if err != nil {
+		t.Fatalf("reading reused CA cert: %v", err)
+	}
+	if string(firstCA) != string(secondCA) {
+		t.Error("second call regenerated the CA cert instead of reusing it")
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"os/user"
@@ -29,10 +31,11 @@ import (
 	"runtime/debug"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/ciao-project/ciao/clogger/gloginterface"
+	"github.com/ciao-project/ciao/clogger/memlog"
 	"github.com/ciao-project/ciao/networking/libsnnet"
 	"github.com/ciao-project/ciao/osprepare"
 	"github.com/ciao-project/ciao/payloads"
@@ -84,11 +87,18 @@ var networking bool
 var hardReset bool
 var diskLimit bool
 var memLimit bool
+var cephIDFlag string
 var cephID string
 var simulate bool
 var childProcessCreds *syscall.SysProcAttr
 var childProcessKVMCreds *syscall.SysProcAttr
 var maxInstances = int(math.MaxInt32)
+var debugAddr string
+var debugLogLines int
+var debugLogBytes int
+var devMode bool
+var shutdownTimeout time.Duration
+var evacuateOnShutdown bool
 
 func init() {
 	flag.StringVar(&serverCertPath, "cacert", "", "Client certificate")
@@ -96,7 +106,13 @@ func init() {
 	flag.BoolVar(&networking, "network", true, "Enable networking")
 	flag.BoolVar(&hardReset, "hard-reset", false, "Kill and delete all instances, reset networking and exit")
 	flag.BoolVar(&simulate, "simulation", false, "Launcher simulation")
-	flag.StringVar(&cephID, "ceph_id", "", "ceph client id")
+	flag.StringVar(&cephIDFlag, "ceph_id", "", "ceph client id.  Overrides the server-provided value on every load, including SIGHUP reloads")
+	flag.StringVar(&debugAddr, "debug-addr", "", "Address for the debug HTTP server, e.g. :9090.  Disabled when empty")
+	flag.IntVar(&debugLogLines, "debug-log-lines", 1000, "Number of log lines to retain for the debug HTTP server")
+	flag.IntVar(&debugLogBytes, "debug-log-bytes", 1<<20, "Number of bytes of log text to retain for the debug HTTP server")
+	flag.BoolVar(&devMode, "dev", false, "Bootstrap a throwaway dev CA and agent certificate when -cacert/-cert are not set.  Never use in production")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", time.Second, "How long to wait for a clean shutdown before panicking with a full goroutine dump")
+	flag.BoolVar(&evacuateOnShutdown, "evacuate-on-shutdown", false, "Evacuate all running instances before shutting down on SIGTERM")
 }
 
 const (
@@ -107,6 +123,7 @@ const (
 	logDir          = ciaoDir + "/logs/launcher"
 	maintenanceFile = dataDir + "/maintenance"
 	networkFile     = dataDir + "/network"
+	devPKIDir       = dataDir + "/dev-pki"
 	instanceState   = "state"
 	lockFile        = "client-agent.lock"
 	statsPeriod     = 6
@@ -119,7 +136,7 @@ func installLauncherDeps(role ssntp.Role, doneCh chan struct{}) {
 	ch := make(chan error)
 	go func() {
 
-		logger := gloginterface.CiaoGlogLogger{}
+		logger := memlog.CiaoGlogLogger{}
 		osprepare.Bootstrap(ctx, logger)
 
 		launcherDeps := osprepare.NewPackageRequirements()
@@ -175,10 +192,23 @@ func processCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- interface{})
 		return
 	}
 
-	switch cmd.cmd.(type) {
+	switch sc := cmd.cmd.(type) {
 	case *statusCmd:
 		ovsCh <- &ovsStatsStatusCmd{}
 		return
+	case *statsQueryCmd:
+		usage, err := (overseerStats{}).LatestInstanceStats(sc.instanceFilter)
+		if err != nil {
+			glog.Errorf("Unable to collect instance stats for %q: %v", sc.instanceFilter, err)
+			return
+		}
+		if err := conn.SendInstanceStats(sc.instanceFilter, usage); err != nil {
+			glog.Errorf("Unable to send instance stats for %q: %v", sc.instanceFilter, err)
+		}
+		return
+	case *reloadCmd:
+		reloadClusterConfig(conn, ovsCh)
+		return
 	case *evacuateCmd:
 		doneCh := make(chan struct{})
 		ovsCh <- &ovsMaintenanceCmd{doneCh}
@@ -229,6 +259,10 @@ func processInstanceCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- inter
 			return
 		}
 		target = addResult.cmdCh
+		st := &stateTransition{state: "running"}
+		if err := st.send(conn, cmd.instance); err != nil {
+			glog.Errorf("Unable to send state transition for %s: %v", cmd.instance, err)
+		}
 	case *insDeleteCmd:
 		insState := insState(cmd.instance, ovsCh)
 		target = insState.cmdCh
@@ -284,14 +318,18 @@ func loadClusterConfig(conn serverConn) error {
 	if err != nil {
 		return err
 	}
-	netConfig.ComputeNet = clusterConfig.Configure.Launcher.ComputeNetwork
-	netConfig.MgmtNet = clusterConfig.Configure.Launcher.ManagementNetwork
-	diskLimit = clusterConfig.Configure.Launcher.DiskLimit
-	memLimit = clusterConfig.Configure.Launcher.MemoryLimit
-	if cephID == "" {
-		cephID = clusterConfig.Configure.Storage.CephID
+
+	newComputeNet := clusterConfig.Configure.Launcher.ComputeNetwork
+	newMgmtNet := clusterConfig.Configure.Launcher.ManagementNetwork
+	newDiskLimit := clusterConfig.Configure.Launcher.DiskLimit
+	newMemLimit := clusterConfig.Configure.Launcher.MemoryLimit
+
+	newCephID := cephIDFlag
+	if newCephID == "" {
+		newCephID = clusterConfig.Configure.Storage.CephID
 	}
 
+	var newChildProcessCreds, newChildProcessKVMCreds *syscall.SysProcAttr
 	childUser := clusterConfig.Configure.Launcher.ChildUser
 	if childUser != "" {
 		usr, err := user.Lookup(childUser)
@@ -310,7 +348,7 @@ func loadClusterConfig(conn serverConn) error {
 		if err != nil {
 			return err
 		}
-		childProcessCreds = &syscall.SysProcAttr{
+		newChildProcessCreds = &syscall.SysProcAttr{
 			Credential: &syscall.Credential{
 				Uid: uint32(uid),
 				Gid: uint32(gid),
@@ -333,7 +371,7 @@ func loadClusterConfig(conn serverConn) error {
 			return err
 		}
 
-		childProcessKVMCreds = &syscall.SysProcAttr{
+		newChildProcessKVMCreds = &syscall.SysProcAttr{
 			Credential: &syscall.Credential{
 				Uid:    uint32(uid),
 				Gid:    uint32(gid),
@@ -342,26 +380,134 @@ func loadClusterConfig(conn serverConn) error {
 		}
 	}
 
-	if err := netConfig.Save(); err != nil {
-		glog.Warningf("Unable to save networking config: %v", err)
+	configMu.Lock()
+	netConfig.ComputeNet = newComputeNet
+	netConfig.MgmtNet = newMgmtNet
+	diskLimit = newDiskLimit
+	memLimit = newMemLimit
+	cephID = newCephID
+	if childUser != "" {
+		childProcessCreds = newChildProcessCreds
+		childProcessKVMCreds = newChildProcessKVMCreds
+	}
+	saveErr := netConfig.Save()
+	configMu.Unlock()
+
+	if saveErr != nil {
+		glog.Warningf("Unable to save networking config: %v", saveErr)
 	}
 
 	return nil
 }
 
-func printClusterConfig() {
-	glog.Info("Cluster Configuration")
-	glog.Info("-----------------------")
-	glog.Infof("Compute Network:      %v", netConfig.ComputeNet)
-	glog.Infof("Management Network:   %v", netConfig.MgmtNet)
-	glog.Infof("Disk Limit:           %v", diskLimit)
-	glog.Infof("Memory Limit:         %v", memLimit)
-	glog.Infof("Ceph ID:              %v", cephID)
+// configMu guards every global that loadClusterConfig mutates:
+// netConfig, diskLimit, memLimit, cephID, childProcessCreds and
+// childProcessKVMCreds.  loadClusterConfig used to run once,
+// synchronously, before the overseer or the debug HTTP server existed,
+// so no lock was needed; now a SIGHUP can trigger it again while both
+// are already running and reading these same globals, so every access
+// in this file goes through configMu.
+var configMu sync.Mutex
+
+func clusterConfigLines() []string {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	lines := []string{
+		"Cluster Configuration",
+		"-----------------------",
+		fmt.Sprintf("Compute Network:      %v", netConfig.ComputeNet),
+		fmt.Sprintf("Management Network:   %v", netConfig.MgmtNet),
+		fmt.Sprintf("Disk Limit:           %v", diskLimit),
+		fmt.Sprintf("Memory Limit:         %v", memLimit),
+		fmt.Sprintf("Ceph ID:              %v", cephID),
+	}
 	if childProcessCreds != nil {
-		glog.Infof("Credentials:          %d:%d",
+		lines = append(lines, fmt.Sprintf("Credentials:          %d:%d",
 			childProcessCreds.Credential.Uid,
-			childProcessCreds.Credential.Gid)
+			childProcessCreds.Credential.Gid))
+	}
+	return lines
+}
+
+func printClusterConfig() {
+	for _, line := range clusterConfigLines() {
+		glog.Info(line)
+	}
+}
+
+// ovsChMu guards currentOvsCh.  Every command sent from
+// connectToServer's own serialised loop is already sequenced before
+// that loop closes currentOvsCh at shutdown, but the debug HTTP server
+// and evacuateThenShutdown have an independent, unbounded lifetime and
+// can still be sending when shutdown happens.  ovsSend and
+// getAllInstancesSafe below hold the read lock for the duration of
+// their send into currentOvsCh, and closeCurrentOvsCh takes the write
+// lock, so the close can never run concurrently with one of their
+// sends and panic on a closed channel.
+var ovsChMu sync.RWMutex
+var currentOvsCh chan<- interface{}
+
+func setCurrentOvsCh(ovsCh chan<- interface{}) {
+	ovsChMu.Lock()
+	currentOvsCh = ovsCh
+	ovsChMu.Unlock()
+}
+
+// closeCurrentOvsCh closes currentOvsCh, if one is set, and clears it.
+// Safe to call even if no overseer is running.
+func closeCurrentOvsCh() {
+	ovsChMu.Lock()
+	if currentOvsCh != nil {
+		close(currentOvsCh)
+		currentOvsCh = nil
+	}
+	ovsChMu.Unlock()
+}
+
+// ovsSend sends cmd to the overseer's command channel and reports
+// whether it was delivered; it returns false without sending if no
+// overseer is currently running.  Safe to call concurrently with
+// closeCurrentOvsCh.
+func ovsSend(cmd interface{}) bool {
+	ovsChMu.RLock()
+	defer ovsChMu.RUnlock()
+	if currentOvsCh == nil {
+		return false
+	}
+	currentOvsCh <- cmd
+	return true
+}
+
+// getAllInstancesSafe is like getAllInstances but, by going through
+// ovsSend instead of taking a channel parameter, is safe to call from
+// a goroutine whose lifetime is independent of connectToServer's (the
+// debug HTTP server, evacuateThenShutdown).  ok is false if no
+// overseer is currently running.
+func getAllInstancesSafe() (instances []ovsInstance, ok bool) {
+	targetCh := make(chan ovsGetAllResult)
+	if !ovsSend(&ovsGetAllCmd{targetCh}) {
+		return nil, false
 	}
+	return (<-targetCh).instances, true
+}
+
+// cmdChMu guards currentCmdCh, which startLauncher's signal handling
+// loop uses to post a reloadCmd on SIGHUP into the same command loop
+// connectToServer already selects on.
+var cmdChMu sync.Mutex
+var currentCmdCh chan<- *cmdWrapper
+
+func setCurrentCmdCh(cmdCh chan<- *cmdWrapper) {
+	cmdChMu.Lock()
+	currentCmdCh = cmdCh
+	cmdChMu.Unlock()
+}
+
+func getCurrentCmdCh() chan<- *cmdWrapper {
+	cmdChMu.Lock()
+	defer cmdChMu.Unlock()
+	return currentCmdCh
 }
 
 func connectToServer(doneCh chan struct{}, statusCh chan struct{}) {
@@ -378,6 +524,8 @@ func connectToServer(doneCh chan struct{}, statusCh chan struct{}) {
 		conn:  &ssntpConn{},
 		cmdCh: make(chan *cmdWrapper),
 	}
+	setCurrentCmdCh(client.cmdCh)
+	defer setCurrentCmdCh(nil)
 
 	var ovsCh chan<- interface{}
 
@@ -424,6 +572,7 @@ func connectToServer(doneCh chan struct{}, statusCh chan struct{}) {
 		defer shutdownNetwork()
 
 		ovsCh = startOverseer(&wg, client)
+		setCurrentOvsCh(ovsCh)
 	case <-doneCh:
 		client.conn.Close()
 		<-dialCh
@@ -453,9 +602,7 @@ DONE:
 		}
 	}
 
-	if ovsCh != nil {
-		close(ovsCh)
-	}
+	closeCurrentOvsCh()
 	wg.Wait()
 	glog.Info("Overseer has closed down")
 }
@@ -543,35 +690,225 @@ func setLimits() {
 	maxInstances = int(rlim.Cur / 5)
 }
 
+func handleDebugLogs(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	tail := 200
+	if t := r.URL.Query().Get("tail"); t != "" {
+		if n, err := strconv.Atoi(t); err == nil {
+			tail = n
+		}
+	}
+
+	for _, e := range memlog.Tail(level, tail) {
+		fmt.Fprintf(w, "%s %s %s\n", e.Time.Format(time.RFC3339), e.Level, e.Message)
+	}
+}
+
+func handleDebugStatus(w http.ResponseWriter, r *http.Request) {
+	for _, line := range clusterConfigLines() {
+		fmt.Fprintln(w, line)
+	}
+
+	instances, ok := getAllInstancesSafe()
+	if !ok {
+		fmt.Fprintln(w, "Overseer is not running")
+		return
+	}
+
+	fmt.Fprintln(w, "Instances")
+	fmt.Fprintln(w, "-----------------------")
+	for _, i := range instances {
+		fmt.Fprintln(w, i.instance)
+	}
+}
+
+// startDebugServer serves live triage information over HTTP so an
+// operator does not have to shell into the node and grep glog files.
+// It is a no-op when debugAddr is empty, and it shuts down along with
+// the rest of the launcher when doneCh is closed.  The listener is not
+// explicitly marked CloseOnExec: net.Listen has returned a
+// SOCK_CLOEXEC socket since Go 1.9, so doing that ourselves would only
+// operate on a dup()'d fd and achieve nothing.
+func startDebugServer(debugAddr string, doneCh <-chan struct{}) {
+	if debugAddr == "" {
+		return
+	}
+
+	ln, err := net.Listen("tcp", debugAddr)
+	if err != nil {
+		glog.Errorf("Unable to start debug server on %s: %v", debugAddr, err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs", handleDebugLogs)
+	mux.HandleFunc("/status", handleDebugStatus)
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("Debug server exited: %v", err)
+		}
+	}()
+
+	go func() {
+		<-doneCh
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			glog.Warningf("Debug server did not shut down cleanly: %v", err)
+		}
+	}()
+
+	glog.Infof("Debug server listening on %s", debugAddr)
+}
+
+// shutdownOnce ensures doneCh is only ever closed once.  startLauncher
+// keeps servicing sigIntCh/sigTermCh while an evacuation triggered by
+// an earlier signal is still draining, so a repeat signal (an operator
+// or init system re-sending SIGTERM, for instance) is expected and
+// must be collapsed rather than re-entering shutdown.
+var shutdownOnce sync.Once
+
+// beginShutdown closes doneCh, which every long running goroutine
+// selects on, and arms timeoutCh to fire after shutdownTimeout so that
+// a goroutine which never quits still gets diagnosed instead of
+// hanging the process forever.  Safe to call more than once; only the
+// first call has any effect.
+func beginShutdown(doneCh chan struct{}, timeoutCh chan struct{}) {
+	shutdownOnce.Do(func() {
+		close(doneCh)
+		go func() {
+			time.Sleep(shutdownTimeout)
+			timeoutCh <- struct{}{}
+		}()
+	})
+}
+
+// shutdownStarted is set the first time a shutdown-triggering signal
+// is handled, so that a second SIGTERM or SIGINT arriving while an
+// evacuation is still draining is recognised and ignored instead of
+// spawning a second, overlapping evacuateThenShutdown.
+var shutdownStarted int32
+
+// tryStartShutdown reports whether this call is the one that should
+// begin shutdown.  It returns false for every call after the first.
+func tryStartShutdown() bool {
+	return atomic.CompareAndSwapInt32(&shutdownStarted, 0, 1)
+}
+
+// evacuateThenShutdown synthesizes an evacuateCmd into the same
+// command path a server-sent evacuate request would take, then polls
+// the overseer while it drains instances, reporting progress once a
+// second.  It gives up and proceeds to the normal shutdown path, which
+// still ends in the panic fallback, once shutdownTimeout elapses.
+func evacuateThenShutdown(doneCh chan struct{}, timeoutCh chan struct{}) {
+	cmdCh := getCurrentCmdCh()
+	instances, ok := getAllInstancesSafe()
+	if cmdCh == nil || !ok {
+		glog.Warning("Not connected to server.  Skipping evacuation")
+		beginShutdown(doneCh, timeoutCh)
+		return
+	}
+
+	total := len(instances)
+	cmdCh <- &cmdWrapper{cmd: &evacuateCmd{}}
+
+	deadline := time.Now().Add(shutdownTimeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		remaining, ok := getAllInstancesSafe()
+		if !ok {
+			glog.Info("Overseer shut down mid-evacuation")
+			break
+		}
+		if len(remaining) == 0 {
+			glog.Info("Evacuation complete")
+			break
+		}
+		if !time.Now().Before(deadline) {
+			glog.Warningf("Evacuation did not complete within %s: %d of %d instances remaining",
+				shutdownTimeout, len(remaining), total)
+			break
+		}
+		glog.Infof("Evacuating %d instances, %d remaining", total, len(remaining))
+
+		select {
+		case <-ticker.C:
+		case <-time.After(time.Until(deadline)):
+		}
+	}
+
+	beginShutdown(doneCh, timeoutCh)
+}
+
 func startLauncher() int {
 	doneCh := make(chan struct{})
 	statusCh := make(chan struct{})
-	signalCh := make(chan os.Signal, 1)
+	sigIntCh := make(chan os.Signal, 1)
+	sigTermCh := make(chan os.Signal, 1)
+	reloadCh := make(chan os.Signal, 1)
 	timeoutCh := make(chan struct{})
-	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(sigIntCh, syscall.SIGINT)
+	signal.Notify(sigTermCh, syscall.SIGTERM)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+
+	startDebugServer(debugAddr, doneCh)
 
 	go connectToServer(doneCh, statusCh)
 
 DONE:
 	for {
 		select {
-		case <-signalCh:
-			glog.Info("Received terminating signal.  Waiting for server loop to quit")
-			close(doneCh)
-			go func() {
-				time.Sleep(time.Second)
-				timeoutCh <- struct{}{}
-			}()
+		case <-sigIntCh:
+			if !tryStartShutdown() {
+				glog.Info("Received SIGINT.  Shutdown already in progress; ignoring")
+				break
+			}
+			glog.Info("Received SIGINT.  Waiting for server loop to quit")
+			beginShutdown(doneCh, timeoutCh)
+		case <-sigTermCh:
+			if !tryStartShutdown() {
+				glog.Info("Received SIGTERM.  Shutdown already in progress; ignoring")
+				break
+			}
+			if evacuateOnShutdown {
+				glog.Info("Received SIGTERM.  Evacuating instances before shutdown")
+				go evacuateThenShutdown(doneCh, timeoutCh)
+			} else {
+				glog.Info("Received SIGTERM.  Waiting for server loop to quit")
+				beginShutdown(doneCh, timeoutCh)
+			}
+		case <-reloadCh:
+			glog.Info("Received SIGHUP.  Reloading cluster configuration")
+			if cmdCh := getCurrentCmdCh(); cmdCh != nil {
+				/*
+					Double check we're not quitting here, the same way the
+					command loop in connectToServer does: cmdCh's reader
+					stops selecting on it as soon as doneCh closes, so an
+					unguarded send here could block forever if that race is
+					lost.
+				*/
+				select {
+				case cmdCh <- &cmdWrapper{cmd: &reloadCmd{}}:
+				case <-doneCh:
+					glog.Info("Received SIGHUP while shutting down.  Ignoring")
+				}
+			} else {
+				glog.Warning("Not connected to server.  Ignoring SIGHUP")
+			}
 		case <-statusCh:
 			glog.Info("Server Loop quit cleanly")
 			break DONE
 		case <-timeoutCh:
-			glog.Warning("Server Loop did not exit within 1 second quitting")
+			glog.Warningf("Server Loop did not exit within %s, quitting", shutdownTimeout)
 			glog.Flush()
 
 			/* We panic here to see which naughty go routines are still running. */
 			debug.SetTraceback("all")
-			panic("Server Loop did not exit within 1 second quitting")
+			panic(fmt.Sprintf("Server Loop did not exit within %s, quitting", shutdownTimeout))
 		}
 	}
 
@@ -592,12 +929,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	libsnnet.Logger = gloginterface.CiaoGlogLogger{}
+	libsnnet.Logger = memlog.CiaoGlogLogger{}
 
 	if err := initLogger(); err != nil {
 		log.Fatalf("Unable to initialise logs: %v", err)
 	}
 
+	memlog.Configure(debugLogLines, debugLogBytes)
+
 	glog.Info("Starting Launcher")
 
 	exitCode := 0
@@ -622,6 +961,12 @@ func main() {
 			glog.Fatalf("Unable to create mandatory dirs: %v", err)
 		}
 
+		if devMode {
+			if err := bootstrapDevMode(); err != nil {
+				glog.Fatalf("Unable to bootstrap --dev PKI: %v", err)
+			}
+		}
+
 		exitCode = startLauncher()
 	}
 
@@ -0,0 +1,118 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+// reloadCmd is posted into the same cmdCh that connectToServer already
+// selects on when SIGHUP is received, so that a configuration reload
+// goes through the same serialised command loop as every other server
+// command instead of racing with it.
+type reloadCmd struct{}
+
+// ovsReconfigureCmd tells the overseer about newly loaded child process
+// credentials and ceph client id.  It only affects instances launched
+// after it is received; instances already running keep the credentials
+// they were started with.
+type ovsReconfigureCmd struct {
+	childProcessCreds    *syscall.SysProcAttr
+	childProcessKVMCreds *syscall.SysProcAttr
+	cephID               string
+}
+
+func credsString(creds *syscall.SysProcAttr) string {
+	if creds == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("%d:%d", creds.Credential.Uid, creds.Credential.Gid)
+}
+
+// reloadClusterConfig re-fetches the cluster configuration from the
+// server, logs what changed and, if the credentials used for newly
+// spawned child processes or the ceph client id changed, fans the
+// update out to the overseer.  Instances that are already running are never touched;
+// only subsequently launched instances pick up the new credentials.
+//
+// This deliberately does not tear down or rebuild any existing
+// tunnels: netConfig.ComputeNet/MgmtNet are refreshed in place for
+// newly launched instances, but reconciling tunnels already serving
+// running instances would require touching the libsnnet-backed
+// networking setup that lives outside this change, so it is left
+// alone here rather than faked with a round trip through networkFile.
+//
+// Before and after values are snapshotted under configMu rather than
+// read directly, since loadClusterConfig can now run concurrently with
+// other goroutines (the overseer, the debug HTTP server) reading the
+// same globals.
+func reloadClusterConfig(conn serverConn, ovsCh chan<- interface{}) {
+	configMu.Lock()
+	oldComputeNet := fmt.Sprintf("%v", netConfig.ComputeNet)
+	oldMgmtNet := fmt.Sprintf("%v", netConfig.MgmtNet)
+	oldDiskLimit := diskLimit
+	oldMemLimit := memLimit
+	oldCephID := cephID
+	oldCreds := credsString(childProcessCreds)
+	configMu.Unlock()
+
+	if err := loadClusterConfig(conn); err != nil {
+		glog.Errorf("Unable to reload cluster configuration: %v", err)
+		return
+	}
+
+	configMu.Lock()
+	newComputeNet := fmt.Sprintf("%v", netConfig.ComputeNet)
+	newMgmtNet := fmt.Sprintf("%v", netConfig.MgmtNet)
+	newDiskLimit := diskLimit
+	newMemLimit := memLimit
+	newCephID := cephID
+	newCreds := credsString(childProcessCreds)
+	newChildProcessCreds := childProcessCreds
+	newChildProcessKVMCreds := childProcessKVMCreds
+	configMu.Unlock()
+
+	credsChanged := oldCreds != newCreds
+
+	if oldComputeNet != newComputeNet {
+		glog.Infof("Compute network changed: %s -> %s", oldComputeNet, newComputeNet)
+	}
+	if oldMgmtNet != newMgmtNet {
+		glog.Infof("Management network changed: %s -> %s", oldMgmtNet, newMgmtNet)
+	}
+	if oldDiskLimit != newDiskLimit {
+		glog.Infof("Disk limit changed: %v -> %v", oldDiskLimit, newDiskLimit)
+	}
+	if oldMemLimit != newMemLimit {
+		glog.Infof("Memory limit changed: %v -> %v", oldMemLimit, newMemLimit)
+	}
+	if oldCephID != newCephID {
+		glog.Infof("Ceph ID changed: %q -> %q", oldCephID, newCephID)
+	}
+	if credsChanged {
+		glog.Infof("Child process credentials changed: %s -> %s", oldCreds, newCreds)
+	}
+
+	printClusterConfig()
+
+	if credsChanged || oldCephID != newCephID {
+		ovsCh <- &ovsReconfigureCmd{newChildProcessCreds, newChildProcessKVMCreds, newCephID}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// statsQueryTimeout bounds how long a statsQueryCmd will wait on the
+// overseer before giving up.  Without this, an overseer that never
+// answers ovsStatsQueryCmd would wedge connectToServer's single
+// serialised command loop forever, along with every command behind it.
+const statsQueryTimeout = 5 * time.Second
+
+// VnicResourceUsage is a point-in-time snapshot of the traffic counters
+// for a single virtual NIC attached to an instance.
+type VnicResourceUsage struct {
+	Name    string
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// InstanceResourceUsage is a point-in-time snapshot of the resources an
+// instance is consuming, cached from the launcher's last collection
+// cycle.
+type InstanceResourceUsage struct {
+	Instance       string
+	CPUTicks       uint64
+	RSS            uint64
+	DiskReadBytes  uint64
+	DiskWriteBytes uint64
+	Vnics          []VnicResourceUsage
+	CollectedAt    time.Time
+}
+
+func (s *InstanceResourceUsage) String() string {
+	return fmt.Sprintf("%s: cpu=%d rss=%d diskRead=%d diskWrite=%d vnics=%d",
+		s.Instance, s.CPUTicks, s.RSS, s.DiskReadBytes, s.DiskWriteBytes, len(s.Vnics))
+}
+
+// AllocStatsReporter is implemented by anything that can report the
+// most recently collected resource usage for an instance without
+// triggering a fresh, potentially expensive, collection pass.
+type AllocStatsReporter interface {
+	LatestInstanceStats(instanceFilter string) (*InstanceResourceUsage, error)
+}
+
+// statsQueryCmd asks the launcher for the cached resource usage of
+// instanceFilter.  processCommand answers it by calling
+// overseerStats.LatestInstanceStats and sending the result back with
+// conn.SendInstanceStats.
+//
+// Nothing in this tree constructs a statsQueryCmd from an incoming
+// SSNTP frame, so the scheduler cannot request one.  The overseer
+// command loop has no case for ovsStatsQueryCmd, so
+// overseerStats.LatestInstanceStats below fails after statsQueryTimeout
+// rather than hanging forever, and processCommand logs that failure
+// instead of sending a reply.
+type statsQueryCmd struct {
+	instanceFilter string
+}
+
+// ovsStatsQueryCmd is the ovsCh command that backs statsQueryCmd: it
+// asks the overseer for the latest cached stats of a single instance
+// rather than collecting fresh ones.
+type ovsStatsQueryCmd struct {
+	instanceFilter string
+	resultCh       chan<- ovsStatsQueryResult
+}
+
+type ovsStatsQueryResult struct {
+	stats *InstanceResourceUsage
+	err   error
+}
+
+// overseerStats implements AllocStatsReporter by asking the overseer,
+// over ovsCh, for the latest cached usage of a single instance.
+type overseerStats struct{}
+
+var _ AllocStatsReporter = overseerStats{}
+
+// LatestInstanceStats implements AllocStatsReporter.  It waits at most
+// statsQueryTimeout for the overseer to answer so a silent or missing
+// dispatch handler cannot wedge the caller's command loop forever.
+func (overseerStats) LatestInstanceStats(instanceFilter string) (*InstanceResourceUsage, error) {
+	targetCh := make(chan ovsStatsQueryResult, 1)
+	if !ovsSend(&ovsStatsQueryCmd{instanceFilter, targetCh}) {
+		return nil, fmt.Errorf("not connected to server")
+	}
+
+	select {
+	case result := <-targetCh:
+		return result.stats, result.err
+	case <-time.After(statsQueryTimeout):
+		return nil, fmt.Errorf("timed out after %s waiting for stats of instance %s", statsQueryTimeout, instanceFilter)
+	}
+}
+
+// stateTransition is the payload sent when an instance's state
+// changes, following the same send(conn, instance) convention as
+// startError and deleteError.
+//
+// A taskReceivedSyncLimit-style debounce that coalesces rapid
+// START -> RUNNING -> FAILED churn into a single send, as originally
+// requested, needs more than one real transition event per instance to
+// coalesce against.  The only transition this tree can observe is the
+// single "add accepted" signal in processInstanceCommand in main.go: the
+// overseer's own instance lifecycle, which would report the later
+// RUNNING/FAILED outcomes a debounce could actually coalesce, lives
+// outside this file and isn't part of this change.  Debouncing that
+// one signal by itself only delayed it by up to 30s with nothing to
+// coalesce it against, and risked telling the scheduler "running" long
+// after a later failure the debounce window had no way to hear about
+// -- a regression, not a feature -- so it is sent immediately here
+// instead.
+type stateTransition struct {
+	state string
+}
+
+func (s *stateTransition) send(conn serverConn, instance string) error {
+	return conn.SendStateChange(instance, s.state)
+}
@@ -0,0 +1,35 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestInstanceResourceUsageString(t *testing.T) {
+	u := &InstanceResourceUsage{
+		Instance:       "instance-1",
+		CPUTicks:       42,
+		RSS:            1024,
+		DiskReadBytes:  10,
+		DiskWriteBytes: 20,
+		Vnics:          []VnicResourceUsage{{Name: "vnic0"}, {Name: "vnic1"}},
+	}
+
+	want := "instance-1: cpu=42 rss=1024 diskRead=10 diskWrite=20 vnics=2"
+	if got := u.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+// Package memlog wraps glog so that recently logged lines are also kept
+// in a bounded in-memory ring buffer.  This lets a caller retrieve the
+// tail of the launcher's log, filtered by verbosity, without having to
+// shell into the node and grep the glog files.
+package memlog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Entry is a single cached log line.
+type Entry struct {
+	Level   string
+	Message string
+	Time    time.Time
+}
+
+type ring struct {
+	mu       sync.Mutex
+	maxLines int
+	maxBytes int
+	bytes    int
+	entries  []Entry
+}
+
+func newRing(maxLines, maxBytes int) *ring {
+	return &ring{maxLines: maxLines, maxBytes: maxBytes}
+}
+
+func (r *ring) append(level, msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, Entry{Level: level, Message: msg, Time: time.Now()})
+	r.bytes += len(msg)
+
+	for (r.maxLines > 0 && len(r.entries) > r.maxLines) || (r.maxBytes > 0 && r.bytes > r.maxBytes) {
+		r.bytes -= len(r.entries[0].Message)
+		r.entries = r.entries[1:]
+	}
+}
+
+func (r *ring) tail(level string, n int) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var filtered []Entry
+	for _, e := range r.entries {
+		if level == "" || e.Level == level {
+			filtered = append(filtered, e)
+		}
+	}
+
+	if n > 0 && n < len(filtered) {
+		filtered = filtered[len(filtered)-n:]
+	}
+
+	return filtered
+}
+
+var defaultRing = newRing(1000, 1<<20)
+
+// Configure sets the capacity of the ring buffer used by CiaoGlogLogger.
+// A maxLines or maxBytes of 0 means that dimension is unbounded. It must
+// be called before the logger is used, normally right after flag.Parse().
+func Configure(maxLines, maxBytes int) {
+	defaultRing = newRing(maxLines, maxBytes)
+}
+
+// Tail returns up to n of the most recently cached log entries at the
+// given level.  An empty level returns entries of all levels.  n <= 0
+// returns every cached entry that matches level.
+func Tail(level string, n int) []Entry {
+	return defaultRing.tail(level, n)
+}
+
+// CiaoGlogLogger logs through glog, as gloginterface.CiaoGlogLogger
+// does, but additionally retains every line in the package's ring
+// buffer so it can be served back over HTTP for live triage.
+type CiaoGlogLogger struct{}
+
+func (l CiaoGlogLogger) Info(args ...interface{}) {
+	msg := fmt.Sprint(args...)
+	defaultRing.append("info", msg)
+	glog.Info(msg)
+}
+
+func (l CiaoGlogLogger) Infof(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	defaultRing.append("info", msg)
+	glog.Info(msg)
+}
+
+func (l CiaoGlogLogger) Warning(args ...interface{}) {
+	msg := fmt.Sprint(args...)
+	defaultRing.append("warning", msg)
+	glog.Warning(msg)
+}
+
+func (l CiaoGlogLogger) Warningf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	defaultRing.append("warning", msg)
+	glog.Warning(msg)
+}
+
+func (l CiaoGlogLogger) Error(args ...interface{}) {
+	msg := fmt.Sprint(args...)
+	defaultRing.append("error", msg)
+	glog.Error(msg)
+}
+
+func (l CiaoGlogLogger) Errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	defaultRing.append("error", msg)
+	glog.Error(msg)
+}
@@ -0,0 +1,88 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package memlog
+
+import "testing"
+
+func TestRingEvictsByMaxLines(t *testing.T) {
+	r := newRing(3, 0)
+
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		r.append("info", msg)
+	}
+
+	got := r.tail("", 0)
+	if len(got) != 3 {
+		t.Fatalf("got %d entries, want 3", len(got))
+	}
+
+	want := []string{"two", "three", "four"}
+	for i, e := range got {
+		if e.Message != want[i] {
+			t.Errorf("entry %d = %q, want %q", i, e.Message, want[i])
+		}
+	}
+}
+
+func TestRingEvictsByMaxBytes(t *testing.T) {
+	r := newRing(0, 6)
+
+	r.append("info", "abc")
+	r.append("info", "def")
+	r.append("info", "ghi")
+
+	got := r.tail("", 0)
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].Message != "def" || got[1].Message != "ghi" {
+		t.Errorf("got %v, want [def ghi]", got)
+	}
+}
+
+func TestRingTailFiltersByLevel(t *testing.T) {
+	r := newRing(0, 0)
+
+	r.append("info", "a")
+	r.append("warning", "b")
+	r.append("error", "c")
+	r.append("warning", "d")
+
+	got := r.tail("warning", 0)
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].Message != "b" || got[1].Message != "d" {
+		t.Errorf("got %v, want [b d]", got)
+	}
+}
+
+func TestRingTailLimitsCount(t *testing.T) {
+	r := newRing(0, 0)
+
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		r.append("info", msg)
+	}
+
+	got := r.tail("", 2)
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].Message != "c" || got[1].Message != "d" {
+		t.Errorf("got %v, want [c d]", got)
+	}
+}